@@ -1,49 +1,131 @@
 package lido
 
 import (
+	"context"
 	"errors"
+	"io"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type (
-	// Options represents a set of pool options
-	Options struct {
-		New     func() (interface{}, error)
-		Size    int
-		Timeout time.Duration
+	// TypedOptions represents a set of pool options for a TypedPool
+	TypedOptions[T any] struct {
+		New            func() (T, error)
+		NewWithContext func(ctx context.Context) (T, error)
+		Size           int
+		Shards         int
+		Timeout        time.Duration
+		IdleTimeout    time.Duration
+		MaxLifetime    time.Duration
+		HealthCheck    func(T) error
+		OnEvent        func(Event)
+		LIFO           bool
+		ShouldRemove   func(error) bool
 	}
 
-	// Pool represents a pool
-	Pool struct {
-		items   chan interface{}
-		newFn   func() (interface{}, error)
-		maxSize int
+	// TypedPool represents a pool of values of type T. Items are
+	// partitioned across a number of shards to reduce mutex contention
+	// under parallel load; see Options.Shards
+	TypedPool[T any] struct {
+		shards       []*shard[T]
+		shardCounter uint64
+		avail        chan struct{}
+		lifo         bool
+		newFn        func(ctx context.Context) (T, error)
+		healthCheck  func(T) error
+		onEvent      func(Event)
+		shouldRemove func(error) bool
+		size         int
+		timeout      time.Duration
+		idleTimeout  time.Duration
+		maxLifetime  time.Duration
+		stopReaper   chan struct{}
+		wg           sync.WaitGroup
+		hits         uint64
+		misses       uint64
+		timeouts     uint64
+		staleConns   uint64
+		waitDuration int64
+	}
+
+	// shard represents a single partition of a TypedPool, with its own
+	// idle item storage and capacity
+	shard[T any] struct {
+		idle    []*pooledItem[T]
 		curSize int
-		timeout time.Duration
+		maxSize int
 		mu      *sync.Mutex
 	}
 
-	// Item represents a pool item
-	Item struct {
-		value   interface{}
+	// Stats represents a snapshot of pool counters, safe to read under load
+	Stats struct {
+		Hits         uint64
+		Misses       uint64
+		Timeouts     uint64
+		TotalConns   uint64
+		IdleConns    uint64
+		StaleConns   uint64
+		WaitDuration time.Duration
+	}
+
+	// Event represents a notable pool occurrence, reported to OnEvent
+	Event struct {
+		Kind     EventKind
+		Duration time.Duration
+	}
+
+	// EventKind represents the kind of an Event
+	EventKind int
+
+	// TypedItem represents a pool item holding a value of type T
+	TypedItem[T any] struct {
+		value   T
 		restore func()
 		remove  func()
 		closed  bool
 		mu      *sync.Mutex
 	}
 
+	// pooledItem wraps a pooled value with the timestamps needed to age it
+	// out via IdleTimeout/MaxLifetime
+	pooledItem[T any] struct {
+		value      T
+		createdAt  time.Time
+		lastUsedAt time.Time
+	}
+
 	closer interface {
 		Close() error
 	}
 )
 
+// EventKind values
+const (
+	// EventHit indicates that Next returned an existing idle item
+	EventHit EventKind = iota
+	// EventMiss indicates that Next had to create a new item
+	EventMiss
+	// EventTimeout indicates that Next timed out waiting for an item
+	EventTimeout
+	// EventStale indicates that an idle or unhealthy item was discarded
+	EventStale
+)
+
 // ErrTimeout indicates that a timeout occured waiting for an available item
 var ErrTimeout = errors.New("pool: timeout waiting for available item")
 
-// New returns a new pool
-func New(o Options) *Pool {
-	if o.New == nil {
+// ErrRemoveItem can be returned, or wrapped, by a Use/UseContext callback to
+// indicate that the item should be removed from the pool instead of
+// restored, e.g. because it failed during use. ShouldRemove can be set to
+// classify other errors the same way
+var ErrRemoveItem = errors.New("pool: item should be removed")
+
+// NewTyped returns a new pool of values of type T
+func NewTyped[T any](o TypedOptions[T]) *TypedPool[T] {
+	if o.New == nil && o.NewWithContext == nil {
 		panic("pool: new func must not be nil")
 	}
 
@@ -55,95 +137,513 @@ func New(o Options) *Pool {
 		o.Timeout = 30 * time.Second
 	}
 
-	return &Pool{
-		items:   make(chan interface{}, o.Size),
-		maxSize: o.Size,
-		timeout: o.Timeout,
-		newFn:   o.New,
-		mu:      new(sync.Mutex),
+	newFn := o.NewWithContext
+	if newFn == nil {
+		newFn = func(context.Context) (T, error) {
+			return o.New()
+		}
+	}
+
+	p := &TypedPool[T]{
+		shards:       newShards[T](o.Size, o.Shards),
+		avail:        make(chan struct{}, o.Size),
+		lifo:         o.LIFO,
+		size:         o.Size,
+		timeout:      o.Timeout,
+		idleTimeout:  o.IdleTimeout,
+		maxLifetime:  o.MaxLifetime,
+		newFn:        newFn,
+		healthCheck:  o.HealthCheck,
+		onEvent:      o.OnEvent,
+		shouldRemove: o.ShouldRemove,
 	}
+
+	p.startReaper()
+
+	return p
 }
 
-// Next returns the next available item in the pool
-func (p *Pool) Next() (*Item, error) {
-	if len(p.items) < 1 {
-		if err := p.addNew(); err != nil {
-			return nil, err
+// newShards partitions size items across n shards, defaulting n to
+// GOMAXPROCS and clamping it to size so that every shard gets at least
+// one slot
+func newShards[T any](size, n int) []*shard[T] {
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	if n > size {
+		n = size
+	}
+	if n < 1 {
+		n = 1
+	}
+
+	base, rem := size/n, size%n
+
+	shards := make([]*shard[T], n)
+	for i := range shards {
+		maxSize := base
+		if i < rem {
+			maxSize++
 		}
+		shards[i] = &shard[T]{maxSize: maxSize, mu: new(sync.Mutex)}
 	}
 
-	select {
-	case v := <-p.items:
-		return &Item{
-			value: v,
-			restore: func() {
-				p.items <- v
-			},
-			remove: func() {
-				p.mu.Lock()
-				defer p.mu.Unlock()
-				p.curSize--
-			},
-			mu: new(sync.Mutex),
-		}, nil
-	case <-time.After(p.timeout):
+	return shards
+}
+
+// Next returns the next available item in the pool, waiting up to the
+// configured timeout for one to become available
+func (p *TypedPool[T]) Next() (*TypedItem[T], error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	item, err := p.NextContext(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
 		return nil, ErrTimeout
 	}
+
+	return item, err
+}
+
+// NextContext returns the next available item in the pool, or an error if
+// ctx is cancelled or its deadline is exceeded before one becomes available.
+// Items that have exceeded IdleTimeout/MaxLifetime or that fail HealthCheck
+// are discarded and replaced transparently. Callers are routed to a shard
+// to minimise contention; a caller whose shard has no idle item of its own
+// steals one from another shard, and a new item is only created in the
+// caller's shard when no shard has an idle item
+func (p *TypedPool[T]) NextContext(ctx context.Context) (*TypedItem[T], error) {
+	start := time.Now()
+
+	for {
+		s, idx := p.pickShard()
+
+		created := false
+		if p.idleLen() < 1 {
+			c, err := p.addNew(ctx, s)
+			if err != nil {
+				return nil, err
+			}
+			created = c
+		}
+
+		select {
+		case <-p.avail:
+			pi := p.popIdle(s)
+			originIdx := idx
+			if pi == nil {
+				pi, originIdx = p.steal(idx)
+			}
+			if pi == nil {
+				// the token didn't correspond to an item we could find;
+				// treat as spurious and retry
+				continue
+			}
+
+			origin := p.shards[originIdx]
+
+			if p.isStale(pi) {
+				p.discard(origin, pi)
+				continue
+			}
+
+			if created {
+				p.recordMiss(time.Since(start))
+			} else {
+				p.recordHit(time.Since(start))
+			}
+
+			return &TypedItem[T]{
+				value: pi.value,
+				restore: func() {
+					pi.lastUsedAt = time.Now()
+					p.pushIdle(origin, pi)
+				},
+				remove: func() {
+					origin.mu.Lock()
+					defer origin.mu.Unlock()
+					origin.curSize--
+				},
+				mu: new(sync.Mutex),
+			}, nil
+		case <-ctx.Done():
+			p.recordTimeout(time.Since(start))
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Use acquires an item, passes it to fn, and restores it to the pool once
+// fn returns. If fn returns an error matching ErrRemoveItem, or one
+// classified by Options.ShouldRemove, the item is removed from the pool
+// instead. The error returned by fn is passed back to the caller unchanged
+func (p *TypedPool[T]) Use(fn func(v T) error) error {
+	item, err := p.Next()
+	if err != nil {
+		return err
+	}
+
+	return p.run(item, fn)
+}
+
+// UseContext is the context-aware equivalent of Use
+func (p *TypedPool[T]) UseContext(ctx context.Context, fn func(v T) error) error {
+	item, err := p.NextContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	return p.run(item, fn)
+}
+
+// run invokes fn with item, restoring or removing it once fn returns. If
+// fn panics, the item is removed rather than silently leaking, and the
+// panic is propagated to the caller
+func (p *TypedPool[T]) run(item *TypedItem[T], fn func(v T) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			item.Remove()
+			panic(r)
+		}
+	}()
+
+	return p.finish(item, fn(item.Value()))
+}
+
+func (p *TypedPool[T]) finish(item *TypedItem[T], err error) error {
+	if p.isRemovable(err) {
+		item.Remove()
+	} else {
+		item.Restore()
+	}
+
+	return err
+}
+
+func (p *TypedPool[T]) isRemovable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, ErrRemoveItem) {
+		return true
+	}
+
+	return p.shouldRemove != nil && p.shouldRemove(err)
 }
 
 // Size returns the pool size
-func (p *Pool) Size() int {
-	return p.maxSize
+func (p *TypedPool[T]) Size() int {
+	return p.size
 }
 
 // Timeout returns the pool timeout
-func (p *Pool) Timeout() time.Duration {
+func (p *TypedPool[T]) Timeout() time.Duration {
 	return p.timeout
 }
 
-// Close closes all items in the pool that implement the Closer interface
-func (p *Pool) Close() error {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	close(p.items)
-	for v := range p.items {
-		if c, ok := v.(closer); ok {
-			if err := c.Close(); err != nil {
-				return err
+// Stats returns a snapshot of the pool counters
+func (p *TypedPool[T]) Stats() Stats {
+	var total, idle int
+	for _, s := range p.shards {
+		s.mu.Lock()
+		total += s.curSize
+		idle += len(s.idle)
+		s.mu.Unlock()
+	}
+
+	return Stats{
+		Hits:         atomic.LoadUint64(&p.hits),
+		Misses:       atomic.LoadUint64(&p.misses),
+		Timeouts:     atomic.LoadUint64(&p.timeouts),
+		TotalConns:   uint64(total),
+		IdleConns:    uint64(idle),
+		StaleConns:   atomic.LoadUint64(&p.staleConns),
+		WaitDuration: time.Duration(atomic.LoadInt64(&p.waitDuration)),
+	}
+}
+
+func (p *TypedPool[T]) recordHit(d time.Duration) {
+	atomic.AddUint64(&p.hits, 1)
+	atomic.AddInt64(&p.waitDuration, int64(d))
+	p.emit(Event{Kind: EventHit, Duration: d})
+}
+
+func (p *TypedPool[T]) recordMiss(d time.Duration) {
+	atomic.AddUint64(&p.misses, 1)
+	atomic.AddInt64(&p.waitDuration, int64(d))
+	p.emit(Event{Kind: EventMiss, Duration: d})
+}
+
+func (p *TypedPool[T]) recordTimeout(d time.Duration) {
+	atomic.AddUint64(&p.timeouts, 1)
+	atomic.AddInt64(&p.waitDuration, int64(d))
+	p.emit(Event{Kind: EventTimeout, Duration: d})
+}
+
+func (p *TypedPool[T]) recordStale() {
+	atomic.AddUint64(&p.staleConns, 1)
+	p.emit(Event{Kind: EventStale})
+}
+
+func (p *TypedPool[T]) emit(e Event) {
+	if p.onEvent != nil {
+		p.onEvent(e)
+	}
+}
+
+// Close closes all items in every shard that implement the Closer
+// interface. It drains all shards regardless of errors, joining and
+// returning any that occurred
+func (p *TypedPool[T]) Close() error {
+	p.stopReaperAndWait()
+
+	var err error
+	for _, s := range p.shards {
+		s.mu.Lock()
+		for _, pi := range s.idle {
+			if c, ok := interface{}(pi.value).(closer); ok {
+				if cerr := c.Close(); cerr != nil {
+					err = errors.Join(err, cerr)
+				}
 			}
 		}
+		s.idle = nil
+		s.mu.Unlock()
 	}
 
-	return nil
+	return err
 }
 
-func (p *Pool) addNew() error {
-	p.mu.Lock()
-	defer p.mu.Unlock()
+// pickShard routes the caller to a shard using a cheap round-robin counter,
+// cycling through shards so that successive callers spread their
+// acquisitions (and the mutex contention that comes with them) across all
+// of them
+func (p *TypedPool[T]) pickShard() (*shard[T], int) {
+	idx := int(atomic.AddUint64(&p.shardCounter, 1) % uint64(len(p.shards)))
+	return p.shards[idx], idx
+}
 
-	if p.curSize >= p.maxSize {
-		return nil
+// idleLen returns the number of idle items across all shards
+func (p *TypedPool[T]) idleLen() int {
+	n := 0
+	for _, s := range p.shards {
+		s.mu.Lock()
+		n += len(s.idle)
+		s.mu.Unlock()
 	}
+	return n
+}
 
-	v, err := p.newFn()
+// addNew reserves capacity in s and creates a new item via p.newFn. The
+// shard lock is not held across the p.newFn call, which may block for the
+// duration of ctx, so that other callers routed to s are not blocked on
+// its completion and remain free to observe their own ctx cancellation
+func (p *TypedPool[T]) addNew(ctx context.Context, s *shard[T]) (bool, error) {
+	s.mu.Lock()
+	if s.curSize >= s.maxSize {
+		s.mu.Unlock()
+		return false, nil
+	}
+	s.curSize++
+	s.mu.Unlock()
+
+	v, err := p.newFn(ctx)
 	if err != nil {
-		return err
+		s.mu.Lock()
+		s.curSize--
+		s.mu.Unlock()
+		return false, err
 	}
 
-	p.items <- v
-	p.curSize++
+	now := time.Now()
 
-	return nil
+	s.mu.Lock()
+	s.idle = append(s.idle, &pooledItem[T]{value: v, createdAt: now, lastUsedAt: now})
+	s.mu.Unlock()
+
+	select {
+	case p.avail <- struct{}{}:
+	default:
+	}
+
+	return true, nil
+}
+
+// popIdle removes and returns the next idle item from s, in FIFO or LIFO
+// order depending on Options.LIFO. It returns nil if s has no idle items
+func (p *TypedPool[T]) popIdle(s *shard[T]) *pooledItem[T] {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.idle) == 0 {
+		return nil
+	}
+
+	if p.lifo {
+		last := len(s.idle) - 1
+		pi := s.idle[last]
+		s.idle = s.idle[:last]
+		return pi
+	}
+
+	pi := s.idle[0]
+	s.idle = s.idle[1:]
+	return pi
+}
+
+// steal looks for an idle item in another shard when the shard at
+// excludeIdx has none of its own, starting with its neighbour and wrapping
+// around the ring. It returns the item and the index of the shard it came
+// from, or nil if every shard is empty
+func (p *TypedPool[T]) steal(excludeIdx int) (*pooledItem[T], int) {
+	n := len(p.shards)
+
+	for i := 1; i <= n; i++ {
+		idx := (excludeIdx + i) % n
+		if pi := p.popIdle(p.shards[idx]); pi != nil {
+			return pi, idx
+		}
+	}
+
+	return nil, -1
+}
+
+// pushIdle returns pi to shard s and wakes a waiting caller, if any
+func (p *TypedPool[T]) pushIdle(s *shard[T], pi *pooledItem[T]) {
+	s.mu.Lock()
+	s.idle = append(s.idle, pi)
+	s.mu.Unlock()
+
+	select {
+	case p.avail <- struct{}{}:
+	default:
+	}
+}
+
+// isStale returns true if pi has exceeded IdleTimeout/MaxLifetime, or fails
+// HealthCheck
+func (p *TypedPool[T]) isStale(pi *pooledItem[T]) bool {
+	now := time.Now()
+
+	if p.idleTimeout > 0 && now.Sub(pi.lastUsedAt) > p.idleTimeout {
+		return true
+	}
+
+	if p.maxLifetime > 0 && now.Sub(pi.createdAt) > p.maxLifetime {
+		return true
+	}
+
+	if p.healthCheck != nil && p.healthCheck(pi.value) != nil {
+		return true
+	}
+
+	return false
+}
+
+// discard closes pi, if possible, and frees its slot in s so a replacement
+// can be created on the next acquisition
+func (p *TypedPool[T]) discard(s *shard[T], pi *pooledItem[T]) {
+	s.mu.Lock()
+	if c, ok := interface{}(pi.value).(closer); ok {
+		c.Close()
+	}
+	s.curSize--
+	s.mu.Unlock()
+
+	p.recordStale()
+}
+
+// startReaper starts a background goroutine that periodically removes idle
+// or expired items from the pool, if IdleTimeout, MaxLifetime or
+// HealthCheck is configured
+func (p *TypedPool[T]) startReaper() {
+	if p.idleTimeout <= 0 && p.maxLifetime <= 0 && p.healthCheck == nil {
+		return
+	}
+
+	p.stopReaper = make(chan struct{})
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		interval := p.reapInterval()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.reap()
+			case <-p.stopReaper:
+				return
+			}
+		}
+	}()
+}
+
+func (p *TypedPool[T]) stopReaperAndWait() {
+	if p.stopReaper == nil {
+		return
+	}
+
+	close(p.stopReaper)
+	p.wg.Wait()
+}
+
+func (p *TypedPool[T]) reapInterval() time.Duration {
+	d := p.idleTimeout
+	if p.maxLifetime > 0 && (d <= 0 || p.maxLifetime < d) {
+		d = p.maxLifetime
+	}
+
+	if d <= 0 {
+		d = time.Second
+	}
+
+	return d
+}
+
+// reap removes stale idle items from every shard, closing them where
+// possible
+func (p *TypedPool[T]) reap() {
+	removed := 0
+
+	for _, s := range p.shards {
+		s.mu.Lock()
+		kept := s.idle[:0]
+		for _, pi := range s.idle {
+			if p.isStale(pi) {
+				if c, ok := interface{}(pi.value).(closer); ok {
+					c.Close()
+				}
+				s.curSize--
+				removed++
+				continue
+			}
+			kept = append(kept, pi)
+		}
+		s.idle = kept
+		s.mu.Unlock()
+	}
+
+	for i := 0; i < removed; i++ {
+		select {
+		case <-p.avail:
+		default:
+		}
+		p.recordStale()
+	}
 }
 
 // Value returns the item value
-func (i *Item) Value() interface{} {
+func (i *TypedItem[T]) Value() T {
 	return i.value
 }
 
 // Restore restores the item value
-func (i *Item) Restore() {
+func (i *TypedItem[T]) Restore() {
 	i.mu.Lock()
 	defer i.mu.Unlock()
 
@@ -156,7 +656,7 @@ func (i *Item) Restore() {
 }
 
 // Remove removes the item value from the pool
-func (i *Item) Remove() {
+func (i *TypedItem[T]) Remove() {
 	i.mu.Lock()
 	defer i.mu.Unlock()
 
@@ -167,3 +667,109 @@ func (i *Item) Remove() {
 	i.remove()
 	i.closed = true
 }
+
+// Close restores the item to the pool, allowing callers to write
+// defer item.Close() instead of defer item.Restore()
+func (i *TypedItem[T]) Close() error {
+	i.Restore()
+	return nil
+}
+
+var _ io.Closer = (*TypedItem[any])(nil)
+
+type (
+	// Options represents a set of pool options
+	Options = TypedOptions[interface{}]
+
+	// Pool represents a pool of interface{} values. It is a thin wrapper
+	// over TypedPool[interface{}], retained for callers that don't need
+	// the type-safety of TypedPool
+	Pool struct {
+		typed *TypedPool[interface{}]
+	}
+
+	// Item represents a pool item
+	Item struct {
+		typed *TypedItem[interface{}]
+	}
+)
+
+// New returns a new pool
+func New(o Options) *Pool {
+	return &Pool{typed: NewTyped(o)}
+}
+
+// Next returns the next available item in the pool
+func (p *Pool) Next() (*Item, error) {
+	i, err := p.typed.Next()
+	if err != nil {
+		return nil, err
+	}
+	return &Item{typed: i}, nil
+}
+
+// NextContext returns the next available item in the pool, or an error if
+// ctx is cancelled or its deadline is exceeded before one becomes available
+func (p *Pool) NextContext(ctx context.Context) (*Item, error) {
+	i, err := p.typed.NextContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Item{typed: i}, nil
+}
+
+// Use acquires an item, passes it to fn, and restores it to the pool once
+// fn returns. If fn returns an error matching ErrRemoveItem, or one
+// classified by Options.ShouldRemove, the item is removed from the pool
+// instead. The error returned by fn is passed back to the caller unchanged
+func (p *Pool) Use(fn func(v interface{}) error) error {
+	return p.typed.Use(fn)
+}
+
+// UseContext is the context-aware equivalent of Use
+func (p *Pool) UseContext(ctx context.Context, fn func(v interface{}) error) error {
+	return p.typed.UseContext(ctx, fn)
+}
+
+// Size returns the pool size
+func (p *Pool) Size() int {
+	return p.typed.Size()
+}
+
+// Timeout returns the pool timeout
+func (p *Pool) Timeout() time.Duration {
+	return p.typed.Timeout()
+}
+
+// Stats returns a snapshot of the pool counters
+func (p *Pool) Stats() Stats {
+	return p.typed.Stats()
+}
+
+// Close closes all items in the pool that implement the Closer interface
+func (p *Pool) Close() error {
+	return p.typed.Close()
+}
+
+// Value returns the item value
+func (i *Item) Value() interface{} {
+	return i.typed.Value()
+}
+
+// Restore restores the item value
+func (i *Item) Restore() {
+	i.typed.Restore()
+}
+
+// Remove removes the item value from the pool
+func (i *Item) Remove() {
+	i.typed.Remove()
+}
+
+// Close restores the item to the pool, allowing callers to write
+// defer item.Close() instead of defer item.Restore()
+func (i *Item) Close() error {
+	return i.typed.Close()
+}
+
+var _ io.Closer = (*Item)(nil)
@@ -1,8 +1,10 @@
 package lido_test
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"sync"
 	"testing"
 	"time"
@@ -27,6 +29,59 @@ func ExampleNew() {
 	// Output: value
 }
 
+func ExampleNewTyped() {
+	pool := lido.NewTyped(lido.TypedOptions[string]{
+		New: func() (string, error) {
+			return "value", nil
+		},
+	})
+
+	item, err := pool.Next()
+	if err != nil {
+		panic(err)
+	}
+	defer item.Restore()
+
+	fmt.Println(item.Value())
+	// Output: value
+}
+
+func TestNewTyped(t *testing.T) {
+	t.Run("should panic if new fn is nil", func(t *testing.T) {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Error("got nil, expected panic")
+			}
+		}()
+
+		lido.NewTyped(lido.TypedOptions[*value]{})
+	})
+
+	t.Run("should return typed values without a cast", func(t *testing.T) {
+		factory := newFactory()
+
+		p := lido.NewTyped(lido.TypedOptions[*value]{
+			New: func() (*value, error) {
+				v, err := factory.create()
+				return v.(*value), err
+			},
+			Size:    2,
+			Timeout: 100 * time.Millisecond,
+		})
+
+		a, err := p.Next()
+		if err != nil {
+			t.Fatalf("got %v, expected nil", err)
+		}
+		defer a.Restore()
+
+		var v *value = a.Value() // no interface{} cast required
+		if v.id != 0 {
+			t.Errorf("got id=%d, expected 0", v.id)
+		}
+	})
+}
+
 func TestNew(t *testing.T) {
 	factory := newFactory()
 
@@ -203,6 +258,455 @@ func TestPool_Next(t *testing.T) {
 	}
 }
 
+func TestPool_NextContext(t *testing.T) {
+	t.Run("should use the context-aware factory", func(t *testing.T) {
+		var got context.Context
+
+		p := lido.New(lido.Options{
+			NewWithContext: func(ctx context.Context) (interface{}, error) {
+				got = ctx
+				return new(value), nil
+			},
+		})
+
+		want := context.WithValue(context.Background(), ctxKey{}, "value")
+		if _, err := p.NextContext(want); err != nil {
+			t.Errorf("got %v, expected nil", err)
+		}
+
+		if got != want {
+			t.Errorf("got %v, expected %v", got, want)
+		}
+	})
+
+	t.Run("should return an error if the context is cancelled", func(t *testing.T) {
+		p := lido.New(lido.Options{
+			New: func() (interface{}, error) {
+				return new(value), nil
+			},
+			Size: 1,
+		})
+
+		if _, err := p.NextContext(context.Background()); err != nil {
+			t.Errorf("got %v, expected nil", err)
+		}
+		// don't restore the item, so the pool is exhausted
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := p.NextContext(ctx)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got %v, expected %v", err, context.Canceled)
+		}
+	})
+
+	t.Run("should not block on another caller's slow factory call", func(t *testing.T) {
+		p := lido.New(lido.Options{
+			NewWithContext: func(ctx context.Context) (interface{}, error) {
+				time.Sleep(300 * time.Millisecond)
+				return new(value), nil
+			},
+			Size:   1,
+			Shards: 1,
+		})
+
+		go p.NextContext(context.Background())
+		time.Sleep(10 * time.Millisecond) // ensure the above has started its factory call
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		if _, err := p.NextContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("got %v, expected %v", err, context.DeadlineExceeded)
+		}
+
+		if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+			t.Errorf("took %v, expected to return near the context deadline", elapsed)
+		}
+	})
+}
+
+func TestPool_NextStaleItems(t *testing.T) {
+	tests := []struct {
+		name    string
+		options lido.Options
+		wait    time.Duration
+	}{
+		{
+			name: "should replace items exceeding the idle timeout",
+			options: lido.Options{
+				IdleTimeout: 10 * time.Millisecond,
+			},
+			wait: 20 * time.Millisecond,
+		},
+		{
+			name: "should replace items exceeding the max lifetime",
+			options: lido.Options{
+				MaxLifetime: 10 * time.Millisecond,
+			},
+			wait: 20 * time.Millisecond,
+		},
+		{
+			name: "should replace items that fail the health check",
+			options: lido.Options{
+				HealthCheck: func(v interface{}) error {
+					if v.(*value).id == 0 {
+						return errBasic
+					}
+					return nil
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factory := newFactory()
+
+			tt.options.New = factory.create
+			tt.options.Size = 2
+			tt.options.Timeout = 100 * time.Millisecond
+
+			p := lido.New(tt.options)
+
+			item, err := p.Next()
+			if err != nil {
+				t.Fatalf("got %v, expected nil", err)
+			}
+			item.Restore()
+
+			time.Sleep(tt.wait)
+
+			item, err = p.Next()
+			if err != nil {
+				t.Fatalf("got %v, expected nil", err)
+			}
+			defer item.Restore()
+
+			exp := 1
+			act := item.Value().(*value).id
+			if act != exp {
+				t.Errorf("got id=%d, expected %d", act, exp)
+			}
+		})
+	}
+}
+
+func TestPool_LIFO(t *testing.T) {
+	tests := []struct {
+		name string
+		lifo bool
+		exp  []int
+	}{
+		{
+			name: "should return items in FIFO order by default",
+			exp:  []int{0, 1},
+		},
+		{
+			name: "should return items in LIFO order when enabled",
+			lifo: true,
+			exp:  []int{1, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factory := newFactory()
+
+			// ordering applies within a shard, so use a single shard
+			// rather than the GOMAXPROCS default
+			p := lido.New(lido.Options{
+				New:     factory.create,
+				Size:    2,
+				Shards:  1,
+				Timeout: 100 * time.Millisecond,
+				LIFO:    tt.lifo,
+			})
+
+			a, err := p.Next()
+			if err != nil {
+				t.Fatalf("got %v, expected nil", err)
+			}
+
+			b, err := p.Next()
+			if err != nil {
+				t.Fatalf("got %v, expected nil", err)
+			}
+
+			a.Restore()
+			b.Restore()
+
+			for _, exp := range tt.exp {
+				item, err := p.Next()
+				if err != nil {
+					t.Fatalf("got %v, expected nil", err)
+				}
+				defer item.Restore()
+
+				if act := item.Value().(*value).id; act != exp {
+					t.Errorf("got id=%d, expected %d", act, exp)
+				}
+			}
+		})
+	}
+}
+
+func TestPool_Shards(t *testing.T) {
+	t.Run("should grow to the maximum size across shards", func(t *testing.T) {
+		factory := newFactory()
+
+		p := lido.New(lido.Options{
+			New:     factory.create,
+			Size:    4,
+			Shards:  2,
+			Timeout: 100 * time.Millisecond,
+		})
+
+		seen := make(map[int]bool)
+		for n := 0; n < 4; n++ {
+			item, err := p.Next()
+			if err != nil {
+				t.Fatalf("got %v, expected nil", err)
+			}
+			// don't restore, so the pool is forced to keep growing
+			seen[item.Value().(*value).id] = true
+		}
+
+		if len(seen) != 4 {
+			t.Errorf("got %d distinct items, expected 4", len(seen))
+		}
+
+		if _, err := p.Next(); err != lido.ErrTimeout {
+			t.Errorf("got %v, expected %v", err, lido.ErrTimeout)
+		}
+	})
+
+	t.Run("should steal idle items from other shards", func(t *testing.T) {
+		factory := newFactory()
+
+		p := lido.New(lido.Options{
+			New:     factory.create,
+			Size:    2,
+			Shards:  2,
+			Timeout: 100 * time.Millisecond,
+		})
+
+		a, err := p.Next() // routed to shard 1 by the round-robin counter
+		if err != nil {
+			t.Fatalf("got %v, expected nil", err)
+		}
+		// leave a checked out, so shard 1 has no idle item of its own
+
+		b, err := p.Next() // routed to shard 0
+		if err != nil {
+			t.Fatalf("got %v, expected nil", err)
+		}
+		b.Restore()
+
+		// the counter routes this call back to shard 1, which is both
+		// empty and at capacity, forcing it through popIdle == nil into
+		// steal, which must find b's item idle in shard 0
+		item, err := p.Next()
+		if err != nil {
+			t.Fatalf("got %v, expected nil", err)
+		}
+		defer item.Restore()
+
+		if act, exp := item.Value().(*value).id, b.Value().(*value).id; act != exp {
+			t.Errorf("got id=%d, expected %d (stolen from the other shard)", act, exp)
+		}
+
+		defer a.Restore()
+	})
+}
+
+func TestPool_Use(t *testing.T) {
+	tests := []struct {
+		name         string
+		shouldRemove func(error) bool
+		fnErr        error
+		expRemoved   bool
+	}{
+		{
+			name: "should restore the item if fn succeeds",
+		},
+		{
+			name:  "should restore the item if fn returns an unclassified error",
+			fnErr: errBasic,
+		},
+		{
+			name:       "should remove the item if fn returns ErrRemoveItem",
+			fnErr:      lido.ErrRemoveItem,
+			expRemoved: true,
+		},
+		{
+			name:       "should remove the item if fn returns an error wrapping ErrRemoveItem",
+			fnErr:      fmt.Errorf("wrapped: %w", lido.ErrRemoveItem),
+			expRemoved: true,
+		},
+		{
+			name:         "should remove the item if ShouldRemove classifies the error",
+			shouldRemove: func(err error) bool { return err == errBasic },
+			fnErr:        errBasic,
+			expRemoved:   true,
+		},
+		{
+			name:         "should remove the item if fn returns ErrRemoveItem even when ShouldRemove does not classify it",
+			shouldRemove: func(err error) bool { return false },
+			fnErr:        lido.ErrRemoveItem,
+			expRemoved:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factory := newFactory()
+
+			p := lido.New(lido.Options{
+				New:          factory.create,
+				Size:         1,
+				Timeout:      100 * time.Millisecond,
+				ShouldRemove: tt.shouldRemove,
+			})
+
+			err := p.Use(func(v interface{}) error {
+				if v.(*value).id != 0 {
+					t.Errorf("got id=%d, expected 0", v.(*value).id)
+				}
+				return tt.fnErr
+			})
+			if err != tt.fnErr {
+				t.Errorf("got %v, expected %v", err, tt.fnErr)
+			}
+
+			item, err := p.Next()
+			if err != nil {
+				t.Fatalf("got %v, expected nil", err)
+			}
+			defer item.Restore()
+
+			exp := 0
+			if tt.expRemoved {
+				exp = 1
+			}
+
+			if act := item.Value().(*value).id; act != exp {
+				t.Errorf("got id=%d, expected %d", act, exp)
+			}
+		})
+	}
+}
+
+func TestPool_UsePanic(t *testing.T) {
+	factory := newFactory()
+
+	p := lido.New(lido.Options{
+		New:     factory.create,
+		Size:    1,
+		Timeout: 100 * time.Millisecond,
+	})
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Error("expected Use to panic")
+			}
+		}()
+
+		p.Use(func(v interface{}) error {
+			panic("boom")
+		})
+	}()
+
+	item, err := p.Next()
+	if err != nil {
+		t.Fatalf("got %v, expected nil", err)
+	}
+	defer item.Restore()
+
+	if act := item.Value().(*value).id; act != 1 {
+		t.Errorf("got id=%d, expected 1", act)
+	}
+}
+
+func TestItem_Close(t *testing.T) {
+	p := lido.New(lido.Options{
+		New: func() (interface{}, error) {
+			return new(value), nil
+		},
+		Size:    1,
+		Timeout: 100 * time.Millisecond,
+	})
+
+	item, err := p.Next()
+	if err != nil {
+		t.Fatalf("got %v, expected nil", err)
+	}
+
+	var _ io.Closer = item
+
+	if err := item.Close(); err != nil {
+		t.Errorf("got %v, expected nil", err)
+	}
+
+	item, err = p.Next() // should have been restored by Close
+	if err != nil {
+		t.Fatalf("got %v, expected nil", err)
+	}
+	defer item.Restore()
+}
+
+func TestPool_Stats(t *testing.T) {
+	factory := newFactory()
+
+	var events []lido.Event
+
+	p := lido.New(lido.Options{
+		New:     factory.create,
+		Size:    1,
+		Timeout: 50 * time.Millisecond,
+		OnEvent: func(e lido.Event) {
+			events = append(events, e)
+		},
+	})
+
+	item, err := p.Next() // miss, pool grows to fill the item
+	if err != nil {
+		t.Fatalf("got %v, expected nil", err)
+	}
+	item.Restore()
+
+	item, err = p.Next() // hit, item already exists
+	if err != nil {
+		t.Fatalf("got %v, expected nil", err)
+	}
+	// don't restore, so the next acquisition times out
+
+	if _, err := p.Next(); err != lido.ErrTimeout { // timeout, pool exhausted
+		t.Errorf("got %v, expected %v", err, lido.ErrTimeout)
+	}
+
+	stats := p.Stats()
+	if stats.Misses != 1 {
+		t.Errorf("got misses=%d, expected 1", stats.Misses)
+	}
+	if stats.Hits != 1 {
+		t.Errorf("got hits=%d, expected 1", stats.Hits)
+	}
+	if stats.Timeouts != 1 {
+		t.Errorf("got timeouts=%d, expected 1", stats.Timeouts)
+	}
+	if stats.TotalConns != 1 {
+		t.Errorf("got totalConns=%d, expected 1", stats.TotalConns)
+	}
+
+	if len(events) != 3 {
+		t.Errorf("got %d events, expected 3", len(events))
+	}
+}
+
 func TestPool_Close(t *testing.T) {
 	tests := []struct {
 		name string
@@ -243,8 +747,12 @@ func TestPool_Close(t *testing.T) {
 			}()
 
 			err := p.Close()
-			if err != tt.err {
-				t.Errorf("got %v, expected %v", err, tt.err)
+			if tt.err == nil {
+				if err != nil {
+					t.Errorf("got %v, expected nil", err)
+				}
+			} else if !errors.Is(err, tt.err) {
+				t.Errorf("got %v, expected an error wrapping %v", err, tt.err)
 			}
 
 			if fi, ok := tt.item.(*value); ok && !fi.closed {
@@ -252,6 +760,45 @@ func TestPool_Close(t *testing.T) {
 			}
 		})
 	}
+
+	t.Run("should drain all shards even if an item in one fails to close", func(t *testing.T) {
+		items := []*value{{closeErr: errBasic}, new(value), new(value), new(value)}
+
+		idx := 0
+		p := lido.New(lido.Options{
+			New: func() (interface{}, error) {
+				v := items[idx]
+				idx++
+				return v, nil
+			},
+			Size:   4,
+			Shards: 4,
+		})
+
+		// hold every item until all have been created, so that each shard
+		// creates one of its own rather than stealing an idle one
+		held := make([]*lido.Item, 0, len(items))
+		for range items {
+			item, err := p.Next()
+			if err != nil {
+				t.Fatalf("got %v, expected nil", err)
+			}
+			held = append(held, item)
+		}
+		for _, item := range held {
+			item.Restore()
+		}
+
+		if err := p.Close(); err == nil {
+			t.Error("got nil, expected an error")
+		}
+
+		for i, v := range items {
+			if !v.closed {
+				t.Errorf("item %d: got closed=false, expected true", i)
+			}
+		}
+	})
 }
 
 func TestPoolParallel(t *testing.T) {
@@ -414,6 +961,8 @@ type (
 		closeErr error
 		closed   bool
 	}
+
+	ctxKey struct{}
 )
 
 var errBasic = errors.New("error")